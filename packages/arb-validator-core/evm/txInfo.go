@@ -0,0 +1,44 @@
+/*
+ * Copyright 2019-2020, Offchain Labs, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package evm
+
+import (
+	"github.com/offchainlabs/arbitrum/packages/arb-util/common"
+	"github.com/offchainlabs/arbitrum/packages/arb-util/value"
+)
+
+// TxInfo describes everything known about a single transaction asserted by a
+// node, including the AVM log witness data needed to build a LogProof.
+type TxInfo struct {
+	Found            bool
+	NodeHeight       uint64
+	NodeHash         common.Hash
+	TransactionHash  common.Hash
+	TransactionIndex uint64
+	RawVal           value.Value
+	LogsPreHash      string
+	LogsPostHash     string
+	LogsValHashes    []string
+	OnChainTxHash    common.Hash
+
+	// Status, ReturnData, and GasUsed carry the EVM execution outcome for
+	// this transaction so that failed and reverted transactions remain
+	// queryable through eth_getTransactionReceipt instead of being dropped.
+	Status     uint8
+	ReturnData []byte
+	GasUsed    uint64
+}
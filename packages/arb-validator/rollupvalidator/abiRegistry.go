@@ -0,0 +1,219 @@
+/*
+ * Copyright 2019-2020, Offchain Labs, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rollupvalidator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/offchainlabs/arbitrum/packages/arb-util/common"
+)
+
+// DecodedEvent is a typed view of an EVM log produced by matching it against
+// a registered contract ABI.
+type DecodedEvent struct {
+	Name      string
+	Inputs    map[string]interface{}
+	Anonymous bool
+}
+
+// ABIRegistry maps a contract address to its parsed ABI so logs emitted by
+// that contract can be decoded into structured events instead of raw
+// addresses and topic hashes. Registrations are persisted alongside a
+// NodeInfoStore's KVStore, if one is supplied, so the registry survives a
+// restart.
+type ABIRegistry struct {
+	mu     sync.RWMutex
+	byAddr map[common.Address]abi.ABI
+
+	db KVStore
+}
+
+// NewABIRegistry creates an empty registry. If db is non-nil, registrations
+// are persisted to it and LoadABIRegistry can later restore them.
+func NewABIRegistry(db KVStore) *ABIRegistry {
+	return &ABIRegistry{
+		byAddr: make(map[common.Address]abi.ABI),
+		db:     db,
+	}
+}
+
+// LoadABIRegistry restores a registry previously persisted to db.
+func LoadABIRegistry(db KVStore) (*ABIRegistry, error) {
+	registry := NewABIRegistry(db)
+	raw, err := db.Get(abiIndexKey)
+	if errors.Is(err, ErrKeyNotFound) {
+		return registry, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var addrs []common.Address
+	if err := json.Unmarshal(raw, &addrs); err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		rawABI, err := db.Get(abiKey(addr))
+		if err != nil {
+			return nil, err
+		}
+		contractABI, err := abi.JSON(bytes.NewReader(rawABI))
+		if err != nil {
+			return nil, err
+		}
+		registry.byAddr[addr] = contractABI
+	}
+	return registry, nil
+}
+
+// RegisterJSON parses rawABI and registers it for contract, persisting it
+// if the registry has a backing store.
+func (r *ABIRegistry) RegisterJSON(contract common.Address, rawABI []byte) error {
+	contractABI, err := abi.JSON(bytes.NewReader(rawABI))
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	_, existed := r.byAddr[contract]
+	r.byAddr[contract] = contractABI
+	r.mu.Unlock()
+
+	if r.db == nil {
+		return nil
+	}
+	if err := r.db.Put(abiKey(contract), rawABI); err != nil {
+		return err
+	}
+	if existed {
+		return nil
+	}
+	return r.appendToIndex(contract)
+}
+
+func (r *ABIRegistry) appendToIndex(contract common.Address) error {
+	raw, err := r.db.Get(abiIndexKey)
+	var addrs []common.Address
+	if err == nil {
+		if err := json.Unmarshal(raw, &addrs); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, ErrKeyNotFound) {
+		return err
+	}
+	addrs = append(addrs, contract)
+	encoded, err := json.Marshal(addrs)
+	if err != nil {
+		return err
+	}
+	return r.db.Put(abiIndexKey, encoded)
+}
+
+// Lookup returns the ABI registered for contract, if any.
+func (r *ABIRegistry) Lookup(contract common.Address) (abi.ABI, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	contractABI, ok := r.byAddr[contract]
+	return contractABI, ok
+}
+
+// FindEventsByName translates a human-friendly event query into the
+// addresses/topics filter FindLogs and FindLogsInRange expect: topics[0] is
+// the event's signature hash, and each subsequent position is either the
+// topic hash for a filtered indexed argument or a wildcard.
+func (r *ABIRegistry) FindEventsByName(contract common.Address, eventName string, indexedFilters map[string]interface{}) ([]common.Address, [][]common.Hash, error) {
+	contractABI, ok := r.Lookup(contract)
+	if !ok {
+		return nil, nil, fmt.Errorf("rollupvalidator: no ABI registered for %v", contract)
+	}
+	event, ok := contractABI.Events[eventName]
+	if !ok {
+		return nil, nil, fmt.Errorf("rollupvalidator: event %q not found in ABI for %v", eventName, contract)
+	}
+
+	query := make([][]interface{}, 0, len(event.Inputs)+1)
+	query = append(query, []interface{}{event.ID})
+	for _, arg := range event.Inputs {
+		if !arg.Indexed {
+			continue
+		}
+		if val, ok := indexedFilters[arg.Name]; ok {
+			query = append(query, []interface{}{val})
+		} else {
+			query = append(query, nil)
+		}
+	}
+
+	topics, err := bind.MakeTopics(query...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return []common.Address{contract}, topics, nil
+}
+
+// decodeLog matches log against contractABI's events and unpacks it into a
+// DecodedEvent. It returns (nil, nil) if the log's first topic does not
+// correspond to a known event, since that's expected for most logs.
+func decodeLog(contractABI abi.ABI, log *types.Log) (*DecodedEvent, error) {
+	if len(log.Topics) == 0 {
+		return nil, nil
+	}
+	event, err := contractABI.EventByID(log.Topics[0])
+	if err != nil {
+		return nil, nil
+	}
+
+	inputs := make(map[string]interface{})
+	if len(log.Data) > 0 {
+		if err := event.Inputs.UnpackIntoMap(inputs, log.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	indexedArgs := make(abi.Arguments, 0)
+	for _, arg := range event.Inputs {
+		if arg.Indexed {
+			indexedArgs = append(indexedArgs, arg)
+		}
+	}
+	if len(indexedArgs) > 0 {
+		if err := abi.ParseTopicsIntoMap(inputs, indexedArgs, log.Topics[1:]); err != nil {
+			return nil, err
+		}
+	}
+
+	return &DecodedEvent{
+		Name:      event.Name,
+		Inputs:    inputs,
+		Anonymous: event.Anonymous,
+	}, nil
+}
+
+var abiIndexKey = []byte("abiIndex")
+
+func abiKey(contract common.Address) []byte {
+	key := make([]byte, 0, len("abi/")+len(contract))
+	key = append(key, "abi/"...)
+	key = append(key, contract.Bytes()...)
+	return key
+}
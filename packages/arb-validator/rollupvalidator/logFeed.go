@@ -0,0 +1,224 @@
+/*
+ * Copyright 2019-2020, Offchain Labs, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rollupvalidator
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/offchainlabs/arbitrum/packages/arb-util/common"
+)
+
+// Subscription is go-ethereum's event.Subscription: Unsubscribe stops
+// delivery, and Err reports why a subscription ended (nil on a clean
+// Unsubscribe).
+type Subscription = event.Subscription
+
+// BackpressurePolicy controls what happens when a LogSubscription's
+// consumer can't keep up with the feed.
+type BackpressurePolicy int
+
+const (
+	// BackpressureBlock makes Publish block until the slow subscriber
+	// drains its channel.
+	BackpressureBlock BackpressurePolicy = iota
+	// BackpressureDropOldest discards the subscriber's oldest buffered
+	// log to make room for the new one rather than blocking Publish.
+	BackpressureDropOldest
+)
+
+// FilterQuery describes an eth_getLogs-style log filter for SubscribeLogs.
+type FilterQuery struct {
+	Addresses []common.Address
+	Topics    [][]common.Hash
+
+	// FromHeight, if set, replays any already-persisted matches starting
+	// at this height before the subscription switches to live delivery.
+	FromHeight *uint64
+}
+
+// HeadEvent announces that a node finished processing.
+type HeadEvent struct {
+	NodeHash   common.Hash
+	NodeHeight uint64
+}
+
+const defaultSubscriptionBuffer = 256
+
+// LogFeed fans out each node's logs, and a HeadEvent, to registered
+// subscribers as soon as processNode's output is published to it. It plays
+// the role go-ethereum's event.Feed plays for live log/header subscriptions,
+// but pre-checks each subscriber's filter against the node's bloom before
+// doing the more expensive per-log match.
+type LogFeed struct {
+	headFeed event.Feed
+
+	mu   sync.Mutex
+	subs map[*logSubscription]struct{}
+
+	// head is the highest NodeHeight ever passed to Publish. subscribeAt
+	// reads it under the same lock it registers a new subscriber with, so
+	// SubscribeLogs can snapshot the replay boundary and start live
+	// delivery as a single atomic step instead of two separate ones a
+	// concurrent Publish could land in between.
+	head uint64
+}
+
+// NewLogFeed creates an empty feed with no subscribers.
+func NewLogFeed() *LogFeed {
+	return &LogFeed{subs: make(map[*logSubscription]struct{})}
+}
+
+// Publish delivers ni's matching logs to every subscriber whose filter
+// passes a bloom pre-check, and announces ni on the head feed.
+func (f *LogFeed) Publish(ni *nodeInfo, registry *ABIRegistry) {
+	bloom := ni.calculateBloomFilter()
+
+	f.mu.Lock()
+	if ni.NodeHeight > f.head {
+		f.head = ni.NodeHeight
+	}
+	subs := make([]*logSubscription, 0, len(f.subs))
+	for sub := range f.subs {
+		subs = append(subs, sub)
+	}
+	f.mu.Unlock()
+
+	for _, sub := range subs {
+		if !bloomMayContain(bloom, sub.addresses, sub.topics) {
+			continue
+		}
+		for _, resp := range ni.FindLogs(sub.addresses, sub.topics, registry) {
+			sub.deliver(resp)
+		}
+	}
+
+	f.headFeed.Send(HeadEvent{NodeHash: ni.NodeHash, NodeHeight: ni.NodeHeight})
+}
+
+// subscribeAt registers a subscriber and returns the current head height in
+// one critical section shared with Publish, so a caller that replays
+// persisted logs up through the returned height and then relies on live
+// delivery for anything after it can't double-deliver or drop a node
+// Inserted concurrently with the call.
+func (f *LogFeed) subscribeAt(addresses []common.Address, topics [][]common.Hash, policy BackpressurePolicy) (*logSubscription, uint64) {
+	sub := &logSubscription{
+		addresses: addresses,
+		topics:    topics,
+		policy:    policy,
+		ch:        make(chan logResponse, defaultSubscriptionBuffer),
+		err:       make(chan error, 1),
+		closed:    make(chan struct{}),
+		feed:      f,
+	}
+	f.mu.Lock()
+	f.subs[sub] = struct{}{}
+	head := f.head
+	f.mu.Unlock()
+	return sub, head
+}
+
+func (f *LogFeed) remove(sub *logSubscription) {
+	f.mu.Lock()
+	delete(f.subs, sub)
+	f.mu.Unlock()
+}
+
+// logSubscription is a single SubscribeLogs registration. It implements
+// Subscription so callers use it the same way they'd use any other
+// go-ethereum event subscription.
+type logSubscription struct {
+	addresses []common.Address
+	topics    [][]common.Hash
+	policy    BackpressurePolicy
+
+	ch     chan logResponse
+	err    chan error
+	closed chan struct{}
+	once   sync.Once
+
+	feed *LogFeed
+}
+
+func (s *logSubscription) deliver(resp logResponse) {
+	if s.policy == BackpressureBlock {
+		select {
+		case s.ch <- resp:
+		case <-s.closed:
+		}
+		return
+	}
+
+	select {
+	case s.ch <- resp:
+		return
+	case <-s.closed:
+		return
+	default:
+	}
+	// Channel is full under BackpressureDropOldest: make room and retry
+	// once, best-effort.
+	select {
+	case <-s.ch:
+	default:
+	}
+	select {
+	case s.ch <- resp:
+	case <-s.closed:
+	default:
+	}
+}
+
+func (s *logSubscription) Unsubscribe() {
+	s.once.Do(func() {
+		close(s.closed)
+		s.feed.remove(s)
+		close(s.err)
+	})
+}
+
+func (s *logSubscription) Err() <-chan error {
+	return s.err
+}
+
+// bloomMayContain reports whether bloom could contain a log matching
+// addresses and topics. A false result is certain; a true result still
+// needs confirming against the actual logs.
+func bloomMayContain(bloom types.Bloom, addresses []common.Address, topics [][]common.Hash) bool {
+	if len(addresses) > 0 && !bloomContainsAny(bloom, addressItems(addresses)) {
+		return false
+	}
+	for _, topicSet := range topics {
+		if len(topicSet) == 0 {
+			continue
+		}
+		if !bloomContainsAny(bloom, hashItems(topicSet)) {
+			return false
+		}
+	}
+	return true
+}
+
+func bloomContainsAny(bloom types.Bloom, items [][]byte) bool {
+	for _, item := range items {
+		if bloom.Test(item) {
+			return true
+		}
+	}
+	return false
+}
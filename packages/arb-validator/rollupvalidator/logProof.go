@@ -0,0 +1,117 @@
+/*
+ * Copyright 2019-2020, Offchain Labs, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rollupvalidator
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/offchainlabs/arbitrum/packages/arb-util/common"
+	"github.com/offchainlabs/arbitrum/packages/arb-util/hashing"
+)
+
+// LogProof is a client-verifiable inclusion witness for a single AVM log
+// within the keccak accumulator chain that processNode builds while walking
+// a node's assertion. Given LogProof, a verifier can recompute the chain
+// and check it against the logsPostHash committed on L1, the same way
+// eth_getProof lets a client verify state inclusion without trusting the
+// node that served it.
+type LogProof struct {
+	PreHash    common.Hash
+	LogValHash common.Hash
+	PostHash   common.Hash
+	NodeHash   common.Hash
+	NodeHeight uint64
+
+	// LogValHashes holds the value hashes of every log after this one in
+	// the node's assertion, in order, needed to walk the accumulator from
+	// LogValHash forward to PostHash.
+	LogValHashes []common.Hash
+}
+
+// buildLogProof builds an inclusion proof for the log at txIndex within ni,
+// reusing the accumulator hashes that processNode already computed. Callers
+// outside this package should go through NodeInfoStore.GetLogProof, which
+// looks ni up by node hash first.
+func (ni *nodeInfo) buildLogProof(txIndex uint64) (LogProof, error) {
+	if int(txIndex) >= len(ni.AVMLogsValHashes) {
+		return LogProof{}, fmt.Errorf("rollupvalidator: log index %v out of range for node %v", txIndex, ni.NodeHash)
+	}
+
+	preHash := common.Hash{}
+	if txIndex > 0 {
+		h, err := decodeAccHash(ni.AVMLogsAccHashes[txIndex-1])
+		if err != nil {
+			return LogProof{}, err
+		}
+		preHash = h
+	}
+
+	logValHash, err := decodeAccHash(ni.AVMLogsValHashes[txIndex])
+	if err != nil {
+		return LogProof{}, err
+	}
+
+	postHash, err := decodeAccHash(ni.AVMLogsAccHashes[len(ni.AVMLogsAccHashes)-1])
+	if err != nil {
+		return LogProof{}, err
+	}
+
+	remaining := ni.AVMLogsValHashes[txIndex+1:]
+	logValHashes := make([]common.Hash, 0, len(remaining))
+	for _, encoded := range remaining {
+		h, err := decodeAccHash(encoded)
+		if err != nil {
+			return LogProof{}, err
+		}
+		logValHashes = append(logValHashes, h)
+	}
+
+	return LogProof{
+		PreHash:      preHash,
+		LogValHash:   logValHash,
+		PostHash:     postHash,
+		NodeHash:     ni.NodeHash,
+		NodeHeight:   ni.NodeHeight,
+		LogValHashes: logValHashes,
+	}, nil
+}
+
+// VerifyLogProof recomputes the accumulator chain starting from proof.PreHash
+// and proof.LogValHash, folding in the remaining LogValHashes in order, and
+// checks the result against both proof.PostHash and expectedFinalAcc.
+func VerifyLogProof(proof LogProof, expectedFinalAcc common.Hash) bool {
+	acc := hashing.SoliditySHA3(
+		hashing.Bytes32(proof.PreHash),
+		hashing.Bytes32(proof.LogValHash),
+	)
+	for _, logValHash := range proof.LogValHashes {
+		acc = hashing.SoliditySHA3(
+			hashing.Bytes32(acc),
+			hashing.Bytes32(logValHash),
+		)
+	}
+	return acc == proof.PostHash && acc == expectedFinalAcc
+}
+
+func decodeAccHash(encoded string) (common.Hash, error) {
+	b, err := hexutil.Decode(encoded)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return common.BytesToHash(b), nil
+}
@@ -0,0 +1,557 @@
+/*
+ * Copyright 2019-2020, Offchain Labs, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rollupvalidator
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/offchainlabs/arbitrum/packages/arb-util/common"
+)
+
+// ErrKeyNotFound is returned by a KVStore implementation when Get is called
+// with a key that has never been written.
+var ErrKeyNotFound = errors.New("rollupvalidator: key not found")
+
+// KVStore is the minimal persistence interface NodeInfoStore needs. It is
+// satisfied by embeddable key-value engines such as BoltDB or Badger so the
+// caller can pick whichever is already in use elsewhere in the node.
+type KVStore interface {
+	Get(key []byte) ([]byte, error)
+	Put(key []byte, value []byte) error
+	Has(key []byte) (bool, error)
+}
+
+// bloomBitLength is the number of bits in a types.Bloom (256 bytes * 8).
+const bloomBitLength = 2048
+
+// defaultSectionSize is the number of consecutive nodes grouped into a
+// single bloom section, matching the scale go-ethereum's chain indexer uses
+// for its bloombits sections.
+const defaultSectionSize = 4096
+
+// NodeInfoStore persists processed nodeInfo values and maintains a
+// section-based bloom bit index over them, so an eth_getLogs-style query
+// can rule out most nodes with cheap bitwise-AND lookups instead of
+// decoding and rescanning every node's bloom filter in the range.
+type NodeInfoStore struct {
+	db          KVStore
+	sectionSize uint64
+
+	mu          sync.Mutex
+	bitmapCache map[bitmapKey][]bool
+
+	// ABIs decodes logs returned by FindLogsInRange when a matching
+	// contract has a registered ABI. Registrations made through it are
+	// persisted to the same db as the node index.
+	ABIs *ABIRegistry
+
+	// Feed fans out each inserted node's logs and head to live
+	// subscribers, and tracks the highest NodeHeight ever Inserted as the
+	// boundary SubscribeLogs replays the persisted store up to before
+	// tailing the live feed; see SubscribeLogs and SubscribeNewHeads.
+	Feed *LogFeed
+}
+
+type bitmapKey struct {
+	section uint64
+	bit     uint
+}
+
+// NewNodeInfoStore wraps db with the node persistence and bloom indexing
+// NodeInfoStore provides, restoring any ABIRegistry entries previously
+// persisted to db. A sectionSize of 0 uses defaultSectionSize.
+func NewNodeInfoStore(db KVStore, sectionSize uint64) (*NodeInfoStore, error) {
+	if sectionSize == 0 {
+		sectionSize = defaultSectionSize
+	}
+	abis, err := LoadABIRegistry(db)
+	if err != nil {
+		return nil, err
+	}
+	return &NodeInfoStore{
+		db:          db,
+		sectionSize: sectionSize,
+		bitmapCache: make(map[bitmapKey][]bool),
+		ABIs:        abis,
+		Feed:        NewLogFeed(),
+	}, nil
+}
+
+// Insert persists ni, folds its bloom filter into the section index, and
+// publishes it to Feed for any live subscribers.
+func (s *NodeInfoStore) Insert(ni *nodeInfo) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(ni); err != nil {
+		return err
+	}
+	if err := s.db.Put(nodeKey(ni.NodeHeight), buf.Bytes()); err != nil {
+		return err
+	}
+	if err := s.db.Put(nodeHashKey(ni.NodeHash), nodeKey(ni.NodeHeight)); err != nil {
+		return err
+	}
+	if err := s.indexBloom(ni.NodeHeight, ni.calculateBloomFilter()); err != nil {
+		return err
+	}
+
+	s.Feed.Publish(ni, s.ABIs)
+	return nil
+}
+
+// SubscribeLogs streams logs matching query as nodes are Inserted. If
+// query.FromHeight is set and not after the current head, it first replays
+// any already-persisted matches from FromHeight through the head before the
+// returned channel switches to live delivery.
+func (s *NodeInfoStore) SubscribeLogs(ctx context.Context, query FilterQuery) (<-chan logResponse, Subscription, error) {
+	// subscribeAt snapshots the head height in the same critical section
+	// that registers sub for live delivery, so a node Inserted concurrently
+	// with this call is either covered by the replay below or delivered
+	// live, never both and never neither.
+	sub, head := s.Feed.subscribeAt(query.Addresses, query.Topics, BackpressureDropOldest)
+
+	if query.FromHeight != nil && *query.FromHeight <= head {
+		replayed, err := s.FindLogsInRange(*query.FromHeight, head, query.Addresses, query.Topics)
+		if err != nil {
+			sub.Unsubscribe()
+			return nil, nil, err
+		}
+		for _, resp := range replayed {
+			sub.deliver(resp)
+		}
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			sub.Unsubscribe()
+		case <-sub.closed:
+		}
+	}()
+
+	return sub.ch, sub, nil
+}
+
+// SubscribeNewHeads streams a HeadEvent for every node Inserted from here
+// on, until ctx is cancelled or the subscription is explicitly closed.
+func (s *NodeInfoStore) SubscribeNewHeads(ctx context.Context) (<-chan HeadEvent, Subscription) {
+	ch := make(chan HeadEvent, defaultSubscriptionBuffer)
+	sub := s.Feed.headFeed.Subscribe(ch)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			sub.Unsubscribe()
+		case <-sub.Err():
+		}
+	}()
+
+	return ch, sub
+}
+
+// Get returns the node persisted at height, or nil if none was ever indexed.
+func (s *NodeInfoStore) Get(height uint64) (*nodeInfo, error) {
+	raw, err := s.db.Get(nodeKey(height))
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	ni := newNodeInfo()
+	if err := gob.NewDecoder(bytes.NewReader(raw)).Decode(ni); err != nil {
+		return nil, err
+	}
+	return ni, nil
+}
+
+// GetByHash returns the node persisted with the given hash, or nil if none
+// was ever indexed.
+func (s *NodeInfoStore) GetByHash(hash common.Hash) (*nodeInfo, error) {
+	raw, err := s.db.Get(nodeHashKey(hash))
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	height := binary.BigEndian.Uint64(raw[len(nodeKeyPrefix):])
+	return s.Get(height)
+}
+
+// GetLogProof builds a LogProof for the log at txIndex within the node
+// identified by nodeHash.
+func (s *NodeInfoStore) GetLogProof(nodeHash common.Hash, txIndex uint64) (LogProof, error) {
+	ni, err := s.GetByHash(nodeHash)
+	if err != nil {
+		return LogProof{}, err
+	}
+	if ni == nil {
+		return LogProof{}, fmt.Errorf("rollupvalidator: no node found with hash %v", nodeHash)
+	}
+	return ni.buildLogProof(txIndex)
+}
+
+// FindLogsInRange answers an eth_getLogs-style query over [fromHeight,
+// toHeight] using the bloom section index to skip nodes that cannot match
+// before decoding and rescanning the surviving candidates individually.
+func (s *NodeInfoStore) FindLogsInRange(fromHeight, toHeight uint64, addresses []common.Address, topics [][]common.Hash) ([]logResponse, error) {
+	if fromHeight > toHeight {
+		return nil, fmt.Errorf("rollupvalidator: invalid range [%v, %v]", fromHeight, toHeight)
+	}
+
+	candidates, err := s.candidateHeights(fromHeight, toHeight, addresses, topics)
+	if err != nil {
+		return nil, err
+	}
+
+	logs := make([]logResponse, 0)
+	for _, height := range candidates {
+		ni, err := s.Get(height)
+		if err != nil {
+			return nil, err
+		}
+		if ni == nil {
+			continue
+		}
+		logs = append(logs, ni.FindLogs(addresses, topics, s.ABIs)...)
+	}
+	return logs, nil
+}
+
+func (s *NodeInfoStore) candidateHeights(fromHeight, toHeight uint64, addresses []common.Address, topics [][]common.Hash) ([]uint64, error) {
+	if len(addresses) == 0 && len(topics) == 0 {
+		heights := make([]uint64, 0, toHeight-fromHeight+1)
+		for h := fromHeight; h <= toHeight; h++ {
+			heights = append(heights, h)
+		}
+		return heights, nil
+	}
+
+	candidates := make([]uint64, 0)
+	for section := fromHeight / s.sectionSize; section <= toHeight/s.sectionSize; section++ {
+		mask, err := s.sectionMatchMask(section, addresses, topics)
+		if err != nil {
+			return nil, err
+		}
+		if mask == nil {
+			continue
+		}
+		base := section * s.sectionSize
+		for offset, matched := range mask {
+			if !matched {
+				continue
+			}
+			height := base + uint64(offset)
+			if height < fromHeight || height > toHeight {
+				continue
+			}
+			candidates = append(candidates, height)
+		}
+	}
+	return candidates, nil
+}
+
+// sectionMatchMask returns, for every node in section, whether it can
+// possibly match addresses AND every non-wildcard topic position. A nil
+// result means nothing in the section can match.
+func (s *NodeInfoStore) sectionMatchMask(section uint64, addresses []common.Address, topics [][]common.Hash) ([]bool, error) {
+	result, err := s.orMatchVector(section, addressItems(addresses))
+	if err != nil {
+		return nil, err
+	}
+	if result == nil && len(addresses) > 0 {
+		return nil, nil
+	}
+	if result == nil {
+		result = allSet(s.sectionSize)
+	}
+
+	for _, topicSet := range topics {
+		if len(topicSet) == 0 {
+			continue
+		}
+		topicMask, err := s.orMatchVector(section, hashItems(topicSet))
+		if err != nil {
+			return nil, err
+		}
+		if topicMask == nil {
+			return nil, nil
+		}
+		result = andMasks(result, topicMask)
+	}
+	return result, nil
+}
+
+// orMatchVector ORs together the per-node match vectors of every item (an
+// address, or one topic position's candidate hashes). A nil, nil result
+// means items was empty (the caller should treat the position as a
+// wildcard).
+func (s *NodeInfoStore) orMatchVector(section uint64, items [][]byte) ([]bool, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+	var result []bool
+	for _, item := range items {
+		v, err := s.itemMatchVector(section, item)
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			result = v
+		} else {
+			result = orMasks(result, v)
+		}
+	}
+	return result, nil
+}
+
+// itemMatchVector ANDs together the bitmaps of the three bloom bits item
+// sets, giving the per-node vector of "this node's bloom could contain
+// item".
+func (s *NodeInfoStore) itemMatchVector(section uint64, item []byte) ([]bool, error) {
+	var result []bool
+	for _, bit := range itemBloomBits(item) {
+		bm, err := s.loadBitmap(section, bit)
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			result = bm
+		} else {
+			result = andMasks(result, bm)
+		}
+	}
+	return result, nil
+}
+
+func (s *NodeInfoStore) indexBloom(height uint64, bloom types.Bloom) error {
+	section := height / s.sectionSize
+	offset := height % s.sectionSize
+	for bit := uint(0); bit < bloomBitLength; bit++ {
+		if !bloomBitIsSet(bloom, bit) {
+			continue
+		}
+		if err := s.setBloomBit(section, bit, offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setBloomBit flips the bit for a single node on in section's per-bit
+// bitmap, holding mu across the load, mutate, and store so two concurrent
+// Inserts folding in the same bitmap (e.g. live processing racing a
+// Reindexer backfill) can't each load the pre-mutation bitmap and clobber
+// one another's bit on store.
+func (s *NodeInfoStore) setBloomBit(section uint64, bit uint, offset uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bm, err := s.loadBitmapLocked(section, bit)
+	if err != nil {
+		return err
+	}
+	bm[offset] = true
+	return s.storeBitmapLocked(section, bit, bm)
+}
+
+func (s *NodeInfoStore) loadBitmap(section uint64, bit uint) ([]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loadBitmapLocked(section, bit)
+}
+
+// loadBitmapLocked is loadBitmap's body with the locking pulled out so
+// setBloomBit can hold mu across both the load and the paired store.
+// Callers must hold s.mu.
+func (s *NodeInfoStore) loadBitmapLocked(section uint64, bit uint) ([]bool, error) {
+	if cached, ok := s.bitmapCache[bitmapKey{section, bit}]; ok {
+		return cached, nil
+	}
+
+	raw, err := s.db.Get(bloomBitsKey(section, bit))
+	if errors.Is(err, ErrKeyNotFound) {
+		return make([]bool, s.sectionSize), nil
+	} else if err != nil {
+		return nil, err
+	}
+	return unpackBits(raw, s.sectionSize), nil
+}
+
+// storeBitmapLocked is storeBitmap's body with the locking pulled out so
+// setBloomBit can hold mu across both the load and the store. Callers must
+// hold s.mu.
+func (s *NodeInfoStore) storeBitmapLocked(section uint64, bit uint, bm []bool) error {
+	s.bitmapCache[bitmapKey{section, bit}] = bm
+	return s.db.Put(bloomBitsKey(section, bit), packBits(bm))
+}
+
+// Reindexer walks a range of previously-processed nodes and backfills them
+// into a NodeInfoStore, for standing the bloom index up after the fact or
+// catching it up if it fell behind.
+type Reindexer struct {
+	store  *NodeInfoStore
+	source func(height uint64) (*nodeInfo, error)
+}
+
+// NewReindexer builds a Reindexer that backfills store with nodes fetched
+// through source, e.g. re-running processNode against archived assertions.
+func NewReindexer(store *NodeInfoStore, source func(height uint64) (*nodeInfo, error)) *Reindexer {
+	return &Reindexer{store: store, source: source}
+}
+
+// Backfill indexes every height in [fromHeight, toHeight] not already
+// present in the store, stopping early if ctx is cancelled.
+func (r *Reindexer) Backfill(ctx context.Context, fromHeight, toHeight uint64) error {
+	for height := fromHeight; height <= toHeight; height++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		existing, err := r.store.Get(height)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			continue
+		}
+
+		ni, err := r.source(height)
+		if err != nil {
+			return err
+		}
+		if ni == nil {
+			continue
+		}
+		if err := r.store.Insert(ni); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// itemBloomBits returns the (at most three) bloom bit positions data sets,
+// reusing go-ethereum's own bloom hashing via a throwaway Bloom.
+func itemBloomBits(data []byte) []uint {
+	var b types.Bloom
+	b.Add(data)
+	bits := make([]uint, 0, 3)
+	for bit := uint(0); bit < bloomBitLength; bit++ {
+		if bloomBitIsSet(b, bit) {
+			bits = append(bits, bit)
+		}
+	}
+	return bits
+}
+
+func bloomBitIsSet(bloom types.Bloom, bit uint) bool {
+	byteIndex := types.BloomByteLength - 1 - int(bit/8)
+	bitMask := byte(1 << (bit % 8))
+	return bloom[byteIndex]&bitMask != 0
+}
+
+func addressItems(addresses []common.Address) [][]byte {
+	items := make([][]byte, len(addresses))
+	for i, addr := range addresses {
+		items[i] = addr.Bytes()
+	}
+	return items
+}
+
+func hashItems(hashes []common.Hash) [][]byte {
+	items := make([][]byte, len(hashes))
+	for i, h := range hashes {
+		items[i] = h.Bytes()
+	}
+	return items
+}
+
+func allSet(n uint64) []bool {
+	mask := make([]bool, n)
+	for i := range mask {
+		mask[i] = true
+	}
+	return mask
+}
+
+func andMasks(a, b []bool) []bool {
+	result := make([]bool, len(a))
+	for i := range a {
+		result[i] = a[i] && b[i]
+	}
+	return result
+}
+
+func orMasks(a, b []bool) []bool {
+	result := make([]bool, len(a))
+	for i := range a {
+		result[i] = a[i] || b[i]
+	}
+	return result
+}
+
+func packBits(bits []bool) []byte {
+	packed := make([]byte, (len(bits)+7)/8)
+	for i, set := range bits {
+		if set {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+	return packed
+}
+
+func unpackBits(packed []byte, n uint64) []bool {
+	bits := make([]bool, n)
+	for i := range bits {
+		if packed[i/8]&(1<<(uint(i)%8)) != 0 {
+			bits[i] = true
+		}
+	}
+	return bits
+}
+
+var nodeKeyPrefix = []byte("node/")
+var nodeHashKeyPrefix = []byte("nodeHash/")
+var bloomBitsKeyPrefix = []byte("bloomBits/")
+
+func nodeKey(height uint64) []byte {
+	key := make([]byte, len(nodeKeyPrefix)+8)
+	copy(key, nodeKeyPrefix)
+	binary.BigEndian.PutUint64(key[len(nodeKeyPrefix):], height)
+	return key
+}
+
+func nodeHashKey(hash common.Hash) []byte {
+	key := make([]byte, 0, len(nodeHashKeyPrefix)+len(hash))
+	key = append(key, nodeHashKeyPrefix...)
+	key = append(key, hash.Bytes()...)
+	return key
+}
+
+func bloomBitsKey(section uint64, bit uint) []byte {
+	key := make([]byte, len(bloomBitsKeyPrefix)+16)
+	copy(key, bloomBitsKeyPrefix)
+	binary.BigEndian.PutUint64(key[len(bloomBitsKeyPrefix):], uint64(bit))
+	binary.BigEndian.PutUint64(key[len(bloomBitsKeyPrefix)+8:], section)
+	return key
+}
@@ -17,6 +17,11 @@
 package rollupvalidator
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/offchainlabs/arbitrum/packages/arb-util/common"
@@ -28,10 +33,31 @@ import (
 	"log"
 )
 
+// TxStatus mirrors the outcome of an AVM assertion's EVM result so that a
+// failed transaction can still be reported through eth_getTransactionReceipt
+// instead of being silently dropped.
+type TxStatus uint8
+
+const (
+	TxStatusSuccess TxStatus = iota
+	TxStatusReverted
+	TxStatusOutOfGas
+	TxStatusBadSequence
+	TxStatusInvalid
+)
+
 type nodeInfo struct {
 	EVMLogs              []logsInfo
 	EVMTransactionHashes []common.Hash
 
+	// EVMTransactionHashes, TxStatuses, ReturnData, and GasUsed are parallel
+	// to the AVM logs this node asserted: index i describes the tx produced
+	// from AVMLogs[i], whether or not evm.ProcessLog could decode it, so that
+	// getTxInfo can index all of them by the same txIndex.
+	TxStatuses []TxStatus
+	ReturnData [][]byte
+	GasUsed    []uint64
+
 	// These members contain the logs and messages from the assertion in this
 	// node if there was one, otherwise they are empty lists
 	AVMLogs     []value.Value
@@ -54,17 +80,147 @@ func newNodeInfo() *nodeInfo {
 	return &nodeInfo{}
 }
 
-func (ni *nodeInfo) FindLogs(addresses []common.Address, topics [][]common.Hash) []logResponse {
+// nodeInfoGobFields is every nodeInfo field gob can encode on its own.
+// AVMLogs and AVMMessages are excluded: they're []value.Value, and value.Value
+// is an interface gob can't encode without every concrete VM value type
+// registered with gob.Register. MarshalBinary/UnmarshalBinary instead encode
+// those through value's own (de)serialization, the same one the VM already
+// uses to checkpoint values.
+type nodeInfoGobFields struct {
+	EVMLogs              []logsInfo
+	EVMTransactionHashes []common.Hash
+	TxStatuses           []TxStatus
+	ReturnData           [][]byte
+	GasUsed              []uint64
+	AVMLogsAccHashes     []string
+	AVMLogsValHashes     []string
+	NodeHash             common.Hash
+	NodeHeight           uint64
+	L1TxHash             common.Hash
+	NumAVMLogs           int
+	NumAVMMessages       int
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, which gob.Encoder uses
+// in place of its default struct encoding. AVMLogs and AVMMessages are
+// appended after the gob-encoded fields using value's own encoding, since
+// value.Value can't be gob-encoded directly.
+func (ni *nodeInfo) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(nodeInfoGobFields{
+		EVMLogs:              ni.EVMLogs,
+		EVMTransactionHashes: ni.EVMTransactionHashes,
+		TxStatuses:           ni.TxStatuses,
+		ReturnData:           ni.ReturnData,
+		GasUsed:              ni.GasUsed,
+		AVMLogsAccHashes:     ni.AVMLogsAccHashes,
+		AVMLogsValHashes:     ni.AVMLogsValHashes,
+		NodeHash:             ni.NodeHash,
+		NodeHeight:           ni.NodeHeight,
+		L1TxHash:             ni.L1TxHash,
+		NumAVMLogs:           len(ni.AVMLogs),
+		NumAVMMessages:       len(ni.AVMMessages),
+	}); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(buf.Len()))
+
+	var values bytes.Buffer
+	for _, v := range ni.AVMLogs {
+		if err := value.MarshalValue(v, &values); err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range ni.AVMMessages {
+		if err := value.MarshalValue(v, &values); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make([]byte, 0, len(header)+buf.Len()+values.Len())
+	out = append(out, header...)
+	out = append(out, buf.Bytes()...)
+	out = append(out, values.Bytes()...)
+	return out, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, the decode side of
+// MarshalBinary. The gob-encoded fields are decoded from their own
+// length-prefixed section so gob's internal buffering can't consume bytes
+// that belong to the value-encoded AVMLogs/AVMMessages section after it.
+func (ni *nodeInfo) UnmarshalBinary(data []byte) error {
+	if len(data) < 4 {
+		return fmt.Errorf("rollupvalidator: nodeInfo encoding too short")
+	}
+	gobLen := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	if uint32(len(data)) < gobLen {
+		return fmt.Errorf("rollupvalidator: nodeInfo encoding truncated")
+	}
+
+	var fields nodeInfoGobFields
+	if err := gob.NewDecoder(bytes.NewReader(data[:gobLen])).Decode(&fields); err != nil {
+		return err
+	}
+	ni.EVMLogs = fields.EVMLogs
+	ni.EVMTransactionHashes = fields.EVMTransactionHashes
+	ni.TxStatuses = fields.TxStatuses
+	ni.ReturnData = fields.ReturnData
+	ni.GasUsed = fields.GasUsed
+	ni.AVMLogsAccHashes = fields.AVMLogsAccHashes
+	ni.AVMLogsValHashes = fields.AVMLogsValHashes
+	ni.NodeHash = fields.NodeHash
+	ni.NodeHeight = fields.NodeHeight
+	ni.L1TxHash = fields.L1TxHash
+
+	values := bytes.NewReader(data[gobLen:])
+	ni.AVMLogs = make([]value.Value, fields.NumAVMLogs)
+	for i := range ni.AVMLogs {
+		v, err := value.UnmarshalValue(values)
+		if err != nil {
+			return err
+		}
+		ni.AVMLogs[i] = v
+	}
+	ni.AVMMessages = make([]value.Value, fields.NumAVMMessages)
+	for i := range ni.AVMMessages {
+		v, err := value.UnmarshalValue(values)
+		if err != nil {
+			return err
+		}
+		ni.AVMMessages[i] = v
+	}
+	return nil
+}
+
+// FindLogs returns every log in ni matching addresses and topics. If
+// registry is non-nil and holds an ABI for a matching log's contract, the
+// returned logResponse carries a decoded event alongside the raw log.
+func (ni *nodeInfo) FindLogs(addresses []common.Address, topics [][]common.Hash, registry *ABIRegistry) []logResponse {
 	logs := make([]logResponse, 0)
 	for _, txLogs := range ni.EVMLogs {
 		for _, evmLog := range txLogs.Logs {
-			if evmLog.MatchesQuery(addresses, topics) {
-				logs = append(logs, logResponse{
-					Log:     evmLog,
-					TxIndex: txLogs.TxIndex,
-					TxHash:  txLogs.TxHash,
-				})
+			if !evmLog.MatchesQuery(addresses, topics) {
+				continue
+			}
+			resp := logResponse{
+				Log:     evmLog,
+				TxIndex: txLogs.TxIndex,
+				TxHash:  txLogs.TxHash,
 			}
+			if registry != nil {
+				if contractABI, ok := registry.Lookup(evmLog.Address); ok {
+					decoded, err := decodeLog(contractABI, evmLog)
+					if err != nil {
+						log.Printf("failed to decode log from %v: %v\n", evmLog.Address, err)
+					} else {
+						resp.Decoded = decoded
+					}
+				}
+			}
+			logs = append(logs, resp)
 		}
 	}
 	return logs
@@ -139,9 +295,48 @@ func hashSlicesEqual(a []common.Hash, b []common.Hash) bool {
 	return true
 }
 
+func txStatusSlicesEqual(a []TxStatus, b []TxStatus) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, t := range a {
+		if t != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func byteSlicesEqual(a [][]byte, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, t := range a {
+		if !bytes.Equal(t, b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func uint64SlicesEqual(a []uint64, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, t := range a {
+		if t != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func (ni *nodeInfo) Equals(o *nodeInfo) bool {
 	return logSlicesEqual(ni.EVMLogs, o.EVMLogs) &&
 		hashSlicesEqual(ni.EVMTransactionHashes, o.EVMTransactionHashes) &&
+		txStatusSlicesEqual(ni.TxStatuses, o.TxStatuses) &&
+		byteSlicesEqual(ni.ReturnData, o.ReturnData) &&
+		uint64SlicesEqual(ni.GasUsed, o.GasUsed) &&
 		valueSlicesEqual(ni.AVMLogs, o.AVMLogs) &&
 		valueSlicesEqual(ni.AVMMessages, o.AVMMessages) &&
 		stringSlicesEqual(ni.AVMLogsAccHashes, o.AVMLogsAccHashes) &&
@@ -181,12 +376,16 @@ func processNode(node *structures.Node, chain common.Address) *nodeInfo {
 			hexutil.Encode(acc.Bytes()))
 	}
 
-	nodeInfo.EVMTransactionHashes = make([]common.Hash, 0, len(logs))
+	nodeInfo.EVMTransactionHashes = make([]common.Hash, len(logs))
+	nodeInfo.TxStatuses = make([]TxStatus, len(logs))
+	nodeInfo.ReturnData = make([][]byte, len(logs))
+	nodeInfo.GasUsed = make([]uint64, len(logs))
 
 	for i, logVal := range logs {
 		evmVal, err := evm.ProcessLog(logVal, chain)
 		if err != nil {
 			log.Printf("VM produced invalid evm result: %v\n", err)
+			nodeInfo.TxStatuses[i] = TxStatusInvalid
 			continue
 		}
 		msg := evmVal.GetEthMsg()
@@ -196,14 +395,39 @@ func processNode(node *structures.Node, chain common.Address) *nodeInfo {
 			TxHash:  msg.TxHash(),
 		})
 
-		if evmVal, ok := evmVal.(evm.Revert); ok {
-			log.Printf("*********** evm.Revert occurred with message \"%v\"\n", string(evmVal.ReturnVal))
+		status, returnData := txStatusAndReturnData(evmVal)
+		if status == TxStatusReverted {
+			log.Printf("*********** evm.Revert occurred with message \"%v\"\n", string(returnData))
 		}
-		nodeInfo.EVMTransactionHashes = append(nodeInfo.EVMTransactionHashes, msg.TxHash())
+
+		nodeInfo.EVMTransactionHashes[i] = msg.TxHash()
+		nodeInfo.TxStatuses[i] = status
+		nodeInfo.ReturnData[i] = returnData
+		nodeInfo.GasUsed[i] = evmVal.GetGasUsed()
 	}
 	return nodeInfo
 }
 
+// txStatusAndReturnData classifies the concrete evm.Result variant produced
+// by an assertion so that failed transactions stay queryable through
+// eth_getTransactionReceipt instead of only being logged and dropped.
+func txStatusAndReturnData(evmVal evm.Result) (TxStatus, []byte) {
+	switch res := evmVal.(type) {
+	case evm.Return:
+		return TxStatusSuccess, res.ReturnVal
+	case evm.Stop:
+		return TxStatusSuccess, nil
+	case evm.Revert:
+		return TxStatusReverted, res.ReturnVal
+	case evm.BadSequenceNum:
+		return TxStatusBadSequence, nil
+	case evm.OutOfGas:
+		return TxStatusOutOfGas, nil
+	default:
+		return TxStatusInvalid, nil
+	}
+}
+
 func getTxInfo(txHash common.Hash, nodeInfo *nodeInfo, txIndex uint64) evm.TxInfo {
 	zero := common.Hash{}
 
@@ -231,5 +455,8 @@ func getTxInfo(txHash common.Hash, nodeInfo *nodeInfo, txIndex uint64) evm.TxInf
 		LogsPostHash:     logsPostHash,
 		LogsValHashes:    logsValHashes,
 		OnChainTxHash:    nodeInfo.L1TxHash,
+		Status:           uint8(nodeInfo.TxStatuses[txIndex]),
+		ReturnData:       nodeInfo.ReturnData[txIndex],
+		GasUsed:          nodeInfo.GasUsed[txIndex],
 	}
 }
\ No newline at end of file